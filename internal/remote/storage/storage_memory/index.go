@@ -0,0 +1,201 @@
+package storage_memory
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// visibilityIndex is a per-queue bbolt index keyed by visibility time
+// (ReenterTime, or the zero time for a never-leased message) so GetMsg can
+// range-scan only the messages that are currently eligible for delivery
+// instead of walking every file in the queue directory. The JSON files
+// remain the source of truth for payloads; the index only ever stores
+// msgID pointers and is rebuilt from disk when its file is missing. An index
+// file that exists is not compared against the message files on disk.
+type visibilityIndex struct {
+	db *bbolt.DB
+}
+
+const (
+	indexFileName   = "index.bolt"
+	indexBucketName = "visibility"
+	byIDBucketName  = "by_id"
+
+	// indexOpenTimeout bounds how long a call waits for another process's
+	// handle on the same index file to close. It is deliberately short:
+	// withQueueIndex only ever holds the handle for a single operation, so a
+	// holder blocking this call is expected to release it almost
+	// immediately, not indefinitely.
+	indexOpenTimeout = 200 * time.Millisecond
+)
+
+// withQueueIndex opens queuePath's index for the duration of fn and closes
+// it again before returning, instead of caching the *bbolt.DB handle across
+// calls. bbolt.Open takes an exclusive file lock for as long as the handle
+// stays open, so caching it would mean a second process touching the same
+// queue blocks on every call for as long as the first process keeps its
+// handle around; opening per-operation bounds that to one logical
+// operation's worth of time. If the index cannot be opened (e.g. another
+// process is mid-operation and this call times out), callers fall back to
+// walking the queue directory directly.
+//
+// If fn itself fails against an otherwise-openable index, the index file is
+// discarded rather than left to drift out of sync with the message files:
+// the next call sees it as missing and rebuilds it from the directory
+// listing, so a single failed write can't make a message permanently
+// invisible for the lifetime of the index file.
+func withQueueIndex(queuePath string, fn func(idx *visibilityIndex) error) error {
+	indexPath := filepath.Join(queuePath, indexFileName)
+	stale := !isFileExists(indexPath)
+
+	db, err := bbolt.Open(indexPath, os.ModePerm, &bbolt.Options{Timeout: indexOpenTimeout})
+	if err != nil {
+		return fmt.Errorf("open index %s failed: %v", indexPath, err)
+	}
+
+	idx := &visibilityIndex{db: db}
+	if stale {
+		if err = idx.rebuild(queuePath); err != nil {
+			_ = db.Close()
+			return err
+		}
+	}
+
+	err = fn(idx)
+	_ = db.Close()
+	if err != nil {
+		_ = os.Remove(indexPath)
+	}
+	return err
+}
+
+// visibilityKey encodes visibleAt as a sortable big-endian prefix so a
+// bucket cursor can range-scan in visibility order. time.Time{} (used for a
+// message that has never been leased) has a UnixNano() that overflows
+// int64/uint64 and would otherwise sort after "now" forever, hiding the
+// message from visibleBefore; normalize it to the Unix epoch instead, which
+// is always <= now.
+func visibilityKey(visibleAt time.Time, msgID string) []byte {
+	if visibleAt.IsZero() {
+		visibleAt = time.Unix(0, 0)
+	}
+	key := make([]byte, 8+len(msgID))
+	binary.BigEndian.PutUint64(key, uint64(visibleAt.UnixNano()))
+	copy(key[8:], msgID)
+	return key
+}
+
+// put records msgID as becoming visible at visibleAt, replacing any earlier
+// entry for the same message. The by-id bucket tracks each message's
+// current visibility key so replacing or removing it is a point lookup
+// rather than a scan over every entry in the bucket.
+func (idx *visibilityIndex) put(msgID string, visibleAt time.Time) error {
+	return idx.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(indexBucketName))
+		if err != nil {
+			return err
+		}
+		idBucket, err := tx.CreateBucketIfNotExists([]byte(byIDBucketName))
+		if err != nil {
+			return err
+		}
+		deletePointEntry(bucket, idBucket, msgID)
+		key := visibilityKey(visibleAt, msgID)
+		if err = bucket.Put(key, []byte(msgID)); err != nil {
+			return err
+		}
+		return idBucket.Put([]byte(msgID), key)
+	})
+}
+
+// delete removes msgID's entry from the index, e.g. after AckMsg.
+func (idx *visibilityIndex) delete(msgID string) error {
+	return idx.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(indexBucketName))
+		idBucket := tx.Bucket([]byte(byIDBucketName))
+		if bucket == nil || idBucket == nil {
+			return nil
+		}
+		deletePointEntry(bucket, idBucket, msgID)
+		return nil
+	})
+}
+
+// deletePointEntry removes msgID's current visibility entry, if any, using
+// the by-id bucket to find it directly instead of scanning the visibility
+// bucket.
+func deletePointEntry(bucket, idBucket *bbolt.Bucket, msgID string) {
+	key := idBucket.Get([]byte(msgID))
+	if key == nil {
+		return
+	}
+	_ = bucket.Delete(key)
+	_ = idBucket.Delete([]byte(msgID))
+}
+
+// visibleBefore returns up to limit msgIDs whose recorded visibility time is
+// at or before now, ordered by visibility time. A limit <= 0 returns all of
+// them.
+func (idx *visibilityIndex) visibleBefore(now time.Time, limit int) ([]string, error) {
+	var ids []string
+	err := idx.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(indexBucketName))
+		if bucket == nil {
+			return nil
+		}
+		nowNanos := uint64(now.UnixNano())
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if binary.BigEndian.Uint64(k[:8]) > nowNanos {
+				break
+			}
+			ids = append(ids, string(v))
+			if limit > 0 && len(ids) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// rebuild repopulates the index from the message files already on disk. It
+// runs only when the index file is missing, including after withQueueIndex
+// has removed it following a failed operation.
+func (idx *visibilityIndex) rebuild(queuePath string) error {
+	return idx.db.Update(func(tx *bbolt.Tx) error {
+		_ = tx.DeleteBucket([]byte(indexBucketName))
+		_ = tx.DeleteBucket([]byte(byIDBucketName))
+		bucket, err := tx.CreateBucket([]byte(indexBucketName))
+		if err != nil {
+			return err
+		}
+		idBucket, err := tx.CreateBucket([]byte(byIDBucketName))
+		if err != nil {
+			return err
+		}
+		return filepath.WalkDir(queuePath, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || d.Name() == metadataFile || filepath.Ext(d.Name()) != ".json" {
+				return nil
+			}
+			msg, err := readMsgLocal(path)
+			if err != nil {
+				return err
+			}
+			key := visibilityKey(msg.ReenterTime, msg.ID)
+			if err = bucket.Put(key, []byte(msg.ID)); err != nil {
+				return err
+			}
+			return idBucket.Put([]byte(msg.ID), key)
+		})
+	})
+}
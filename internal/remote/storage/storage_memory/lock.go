@@ -0,0 +1,44 @@
+package storage_memory
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const queueLockFileName = ".lock"
+
+// fileLock is an OS-level advisory lock on a per-queue lockfile. It
+// serializes the read-filter-rewrite critical section of GetMsg and AckMsg
+// across processes sharing storageDir, since the in-process sharedMsgState
+// alone cannot stop two separate processes from both picking up the same
+// visible message. Platform-specific locking lives in lock_unix.go and
+// lock_windows.go.
+type fileLock struct {
+	f *os.File
+}
+
+func newFileLock(queuePath string) (*fileLock, error) {
+	f, err := os.OpenFile(filepath.Join(queuePath, queueLockFileName), os.O_CREATE|os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &fileLock{f: f}, nil
+}
+
+// withQueueLock runs fn while holding an exclusive lock on queuePath's
+// lockfile, releasing it and closing the handle on the way out regardless of
+// how fn returns.
+func withQueueLock(queuePath string, fn func() error) error {
+	lock, err := newFileLock(queuePath)
+	if err != nil {
+		return err
+	}
+	defer lock.f.Close()
+
+	if err = lock.Lock(); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	return fn()
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package storage_memory
+
+import "golang.org/x/sys/unix"
+
+func (l *fileLock) Lock() error {
+	return unix.Flock(int(l.f.Fd()), unix.LOCK_EX)
+}
+
+func (l *fileLock) Unlock() error {
+	return unix.Flock(int(l.f.Fd()), unix.LOCK_UN)
+}
@@ -0,0 +1,62 @@
+package storage_memory
+
+import (
+	"context"
+	"time"
+)
+
+// reenterPollInterval bounds how long a long-poll GetMsg can sleep past a
+// message's ReenterTime before it is rechecked, independent of notifyQueue.
+const reenterPollInterval = time.Second
+
+// notifyQueue wakes any goroutine currently long-polling GetMsg on queueId.
+// CreateMsg, AckMsg and ExtendMsg call this after a write that could make a
+// message newly visible.
+func (c *LocalClient) notifyQueue(queueId string) {
+	c.notifyMu.Lock()
+	defer c.notifyMu.Unlock()
+
+	if c.notifiers == nil {
+		return
+	}
+	if ch, ok := c.notifiers[queueId]; ok {
+		close(ch)
+		delete(c.notifiers, queueId)
+	}
+}
+
+// waitQueue blocks until notifyQueue(queueId) is called, the reenter-time
+// ticker fires, the wait deadline passes, or ctx is done, whichever happens
+// first. Waiters on the same queue share one channel: the first waiter after
+// a notify registers it and later callers reuse it, so notifyQueue wakes them
+// all with a single close and never has to know how many there are. A call
+// does not get a channel of its own, and the channel is not closed when a
+// waiter times out or ctx is done.
+func (c *LocalClient) waitQueue(ctx context.Context, queueId string, deadline time.Time) {
+	c.notifyMu.Lock()
+	if c.notifiers == nil {
+		c.notifiers = make(map[string]chan struct{})
+	}
+	ch, ok := c.notifiers[queueId]
+	if !ok {
+		ch = make(chan struct{})
+		c.notifiers[queueId] = ch
+	}
+	c.notifyMu.Unlock()
+
+	wait := time.Until(deadline)
+	if wait <= 0 {
+		return
+	}
+	if wait > reenterPollInterval {
+		wait = reenterPollInterval
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -30,12 +31,13 @@ func (c *LocalClient) CreateQueue(ctx context.Context, req *models.CreateQueueRe
 		return nil, err
 	}
 	queue := &models.Queue{
-		Id:          id,
-		Description: req.Description,
-		Name:        req.Name,
-		RunId:       req.RunId,
-		ActorId:     req.ActorId,
-		CreatedAt:   time.Now().Format(time.RFC3339Nano),
+		Id:                id,
+		Description:       req.Description,
+		Name:              req.Name,
+		RunId:             req.RunId,
+		ActorId:           req.ActorId,
+		DeadLetterQueueId: req.DeadLetterQueueId,
+		CreatedAt:         time.Now().Format(time.RFC3339Nano),
 	}
 
 	if err = c.updateMetadata(queue); err != nil {
@@ -150,6 +152,7 @@ func (c *LocalClient) UpdateQueue(ctx context.Context, req *models.UpdateQueueRe
 
 	queue.Name = req.Name
 	queue.Description = req.Description
+	queue.DeadLetterQueueId = req.DeadLetterQueueId
 
 	return c.updateMetadata(&queue)
 }
@@ -191,118 +194,368 @@ func (c *LocalClient) CreateMsg(ctx context.Context, req *models.CreateMsgReques
 		return nil, fmt.Errorf("json marshal failed: %s", err)
 	}
 
-	if err = os.WriteFile(msgPath, marshal, os.ModePerm); err != nil {
+	err = withQueueLock(queuePath, func() error {
+		if err = os.WriteFile(msgPath, marshal, os.ModePerm); err != nil {
+			return err
+		}
+		// the index is an accelerator, not the source of truth: a failure to
+		// record this message in it must not undo the write above, so it is
+		// deliberately not propagated as a CreateMsg error
+		_ = withQueueIndex(queuePath, func(idx *visibilityIndex) error {
+			return idx.put(id, time.Time{})
+		})
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
+	c.notifyQueue(req.QueueId)
 	return &models.CreateMsgResponse{
 		MsgId: id,
 	}, nil
 }
 
+// GetMsg returns up to req.Limit visible messages from the queue. When
+// req.WaitSeconds is positive and the first pass finds nothing, it long-polls:
+// blocking until a write makes a message visible, the reenter-time ticker
+// fires, or the wait deadline/ctx is done, whichever comes first. This mirrors
+// the remote client's signature so callers can switch backends transparently.
 func (c *LocalClient) GetMsg(ctx context.Context, req *models.GetMsgRequest) (*models.GetMsgResponse, error) {
+	deadline := time.Now().Add(time.Duration(req.WaitSeconds) * time.Second)
+	for {
+		resp, err := c.scanMsg(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if len(*resp) > 0 {
+			return resp, nil
+		}
+		// distinguish a caller whose wait was canceled/timed out from one
+		// that genuinely polled an empty queue
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if req.WaitSeconds <= 0 || !time.Now().Before(deadline) {
+			return resp, nil
+		}
+		c.waitQueue(ctx, req.QueueId, deadline)
+	}
+}
+
+func (c *LocalClient) scanMsg(ctx context.Context, req *models.GetMsgRequest) (*models.GetMsgResponse, error) {
 	queuePath := filepath.Join(storageDir, queueDir, req.QueueId)
 
-	msgs := make([]*models.MsgLocal, 0)
-	now := time.Now()
-	err := filepath.WalkDir(queuePath, func(path string, d fs.DirEntry, err error) error {
+	queue, err := c.readQueueMetadata(req.QueueId)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp models.GetMsgResponse
+	err = withQueueLock(queuePath, func() error {
+		now := time.Now()
+		candidateIds, err := c.candidateMsgIds(queuePath, now)
 		if err != nil {
 			return err
 		}
-		if d.IsDir() || d.Name() == metadataFile {
-			return nil
+
+		msgs := make([]*models.MsgLocal, 0, len(candidateIds))
+		for _, id := range candidateIds {
+			msgPath := filepath.Join(queuePath, fmt.Sprintf("%s.json", id))
+			msg, err := readMsgLocal(msgPath)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			// already dead-lettered: hand it back as-is, no further retry bookkeeping
+			if msg.FailedAt > 0 {
+				msgs = append(msgs, msg)
+				continue
+			}
+			// msg succeeded or exhausted its deadline/retries
+			if msg.SuccessAt > 0 || msg.Deadline < now.Unix() ||
+				(msg.ReenterTime.Before(now) && msg.Retried >= msg.Retry) {
+				if queue.DeadLetterQueueId != "" && msg.SuccessAt == 0 {
+					reason := "retries exhausted"
+					if msg.Deadline < now.Unix() {
+						reason = "timeout"
+					}
+					if err = c.moveToDeadLetter(msg, queue.DeadLetterQueueId, reason); err != nil {
+						return err
+					}
+				}
+				_ = os.Remove(msgPath)
+				_ = withQueueIndex(queuePath, func(idx *visibilityIndex) error {
+					return idx.delete(msg.ID)
+				})
+				c.sharedState.release(msg.ID)
+				continue
+			}
+			// msg is not reenter queue
+			if !msg.ReenterTime.Equal(time.Time{}) && msg.ReenterTime.After(now) {
+				continue
+			}
+
+			msgs = append(msgs, msg)
+		}
+		sort.Slice(msgs, func(i, j int) bool {
+			return msgs[i].UpdateTime.Before(msgs[j].UpdateTime)
+		})
+		if len(msgs) > int(req.Limit) {
+			msgs = msgs[:req.Limit]
+		}
+
+		respMsg := make([]*models.Msg, 0, len(msgs))
+		for _, msg := range msgs {
+			// a message already sitting in a DLQ keeps its retry count untouched
+			if msg.FailedAt == 0 {
+				newReenter := now.Add(time.Duration(msg.Timeout) * time.Second)
+				// the in-process lease map is checked in addition to the
+				// OS-level lock above: a message this LocalClient still holds
+				// an unexpired lease on is not redelivered
+				if !c.sharedState.lease(msg.ID, newReenter) {
+					continue
+				}
+				msg.ReenterTime = newReenter
+				msg.Retried++
+				msgPath := filepath.Join(queuePath, fmt.Sprintf("%s.json", msg.ID))
+				marshal, err := json.Marshal(msg)
+				if err != nil {
+					return fmt.Errorf("json marshal failed: %s", err)
+				}
+				if err = os.WriteFile(msgPath, marshal, os.ModePerm); err != nil {
+					return fmt.Errorf("write file %s failed: %v", msgPath, err)
+				}
+				_ = withQueueIndex(queuePath, func(idx *visibilityIndex) error {
+					return idx.put(msg.ID, msg.ReenterTime)
+				})
+			}
+
+			respMsg = append(respMsg, &models.Msg{
+				ID:        msg.ID,
+				QueueID:   msg.QueueID,
+				Name:      msg.Name,
+				Payload:   msg.Payload,
+				Timeout:   msg.Timeout,
+				Deadline:  msg.Deadline,
+				Retry:     msg.Retry,
+				Retried:   msg.Retried,
+				SuccessAt: msg.SuccessAt,
+				FailedAt:  msg.FailedAt,
+				Desc:      msg.Desc,
+			})
+		}
+		resp = respMsg
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *LocalClient) AckMsg(ctx context.Context, req *models.AckMsgRequest) error {
+	queuePath := filepath.Join(storageDir, queueDir, req.QueueId)
+	msgPath := filepath.Join(queuePath, fmt.Sprintf("%s.json", req.MsgId))
+
+	err := withQueueLock(queuePath, func() error {
+		if !isFileExists(msgPath) {
+			return ErrResourceNotFound
 		}
-		msgPath := filepath.Join(queuePath, d.Name())
+
 		buf, err := os.ReadFile(msgPath)
 		if err != nil {
-			return fmt.Errorf("read file %s failed: %v", path, err)
+			return err
 		}
 		var msg models.MsgLocal
 		err = json.Unmarshal(buf, &msg)
 		if err != nil {
 			return fmt.Errorf("json unmarshal failed: %s", err)
 		}
-		// msg is finished
-		if msg.SuccessAt > 0 || msg.FailedAt > 0 || msg.Deadline < now.Unix() ||
-			(msg.ReenterTime.Before(now) && msg.Retried >= msg.Retry) {
-			_ = os.Remove(msgPath)
-			return nil
-		}
-		// msg is not reenter queue
-		if !msg.ReenterTime.Equal(time.Time{}) && msg.ReenterTime.After(now) {
-			return nil
+		if msg.ReenterTime.Equal(time.Time{}) {
+			return ErrResourceNotFound
 		}
 
-		msgs = append(msgs, &msg)
-
+		if msg.ReenterTime.Before(time.Now()) {
+			return errors.New("msg is timeout, you must ack within the timeout period")
+		}
+		if err = os.Remove(msgPath); err != nil {
+			return fmt.Errorf("delete file %s failed: %v", msgPath, err)
+		}
+		_ = withQueueIndex(queuePath, func(idx *visibilityIndex) error {
+			return idx.delete(req.MsgId)
+		})
+		c.sharedState.release(req.MsgId)
 		return nil
 	})
 	if err != nil {
-		return nil, err
-	}
-	sort.Slice(msgs, func(i, j int) bool {
-		return msgs[i].UpdateTime.Before(msgs[j].UpdateTime)
-	})
-	if len(msgs) > int(req.Limit) {
-		msgs = msgs[:req.Limit]
+		return err
 	}
+	c.notifyQueue(req.QueueId)
+	return nil
+}
 
-	respMsg := make([]*models.Msg, 0, len(msgs))
-	for _, msg := range msgs {
-		msg.ReenterTime = now.Add(time.Duration(msg.Timeout) * time.Second)
-		msg.Retried++
-		msgPath := filepath.Join(queuePath, fmt.Sprintf("%s.json", msg.ID))
-		marshal, err := json.Marshal(msg)
+// ExtendMsg pushes ReenterTime forward by req.ExtendSeconds, letting a
+// consumer that is still processing a message keep its lease alive instead
+// of racing the original Timeout.
+func (c *LocalClient) ExtendMsg(ctx context.Context, req *models.ExtendMsgRequest) error {
+	queuePath := filepath.Join(storageDir, queueDir, req.QueueId)
+	msgPath := filepath.Join(queuePath, fmt.Sprintf("%s.json", req.MsgId))
+
+	err := withQueueLock(queuePath, func() error {
+		if !isFileExists(msgPath) {
+			return ErrResourceNotFound
+		}
+
+		buf, err := os.ReadFile(msgPath)
 		if err != nil {
-			return nil, fmt.Errorf("json marshal failed: %s", err)
+			return err
 		}
-		if err = os.WriteFile(msgPath, marshal, os.ModePerm); err != nil {
-			return nil, fmt.Errorf("write file %s failed: %v", msgPath, err)
+		var msg models.MsgLocal
+		err = json.Unmarshal(buf, &msg)
+		if err != nil {
+			return fmt.Errorf("json unmarshal failed: %s", err)
+		}
+		if msg.ReenterTime.Equal(time.Time{}) {
+			return ErrResourceNotFound
+		}
+		if msg.ReenterTime.Before(time.Now()) {
+			return errors.New("msg is timeout, you must extend within the timeout period")
 		}
 
-		respMsg = append(respMsg, &models.Msg{
-			ID:        msg.ID,
-			QueueID:   msg.QueueID,
-			Name:      msg.Name,
-			Payload:   msg.Payload,
-			Timeout:   msg.Timeout,
-			Deadline:  msg.Deadline,
-			Retry:     msg.Retry,
-			Retried:   msg.Retried,
-			SuccessAt: msg.SuccessAt,
-			FailedAt:  msg.FailedAt,
-			Desc:      msg.Desc,
+		msg.ReenterTime = msg.ReenterTime.Add(time.Duration(req.ExtendSeconds) * time.Second)
+
+		marshal, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("json marshal failed: %s", err)
+		}
+		if err = writeFileAtomic(msgPath, marshal); err != nil {
+			return err
+		}
+		_ = withQueueIndex(queuePath, func(idx *visibilityIndex) error {
+			return idx.put(msg.ID, msg.ReenterTime)
 		})
+		c.sharedState.lease(msg.ID, msg.ReenterTime)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	resp := models.GetMsgResponse(respMsg)
-	return &resp, nil
+	c.notifyQueue(req.QueueId)
+	return nil
 }
 
-func (c *LocalClient) AckMsg(ctx context.Context, req *models.AckMsgRequest) error {
-	msgPath := filepath.Join(storageDir, queueDir, req.QueueId, fmt.Sprintf("%s.json", req.MsgId))
-	if !isFileExists(msgPath) {
+// PurgeDeadLetters removes every message currently sitting in a DLQ.
+func (c *LocalClient) PurgeDeadLetters(ctx context.Context, req *models.PurgeDeadLettersRequest) error {
+	dlqPath := filepath.Join(storageDir, queueDir, req.QueueId)
+	if !isDirExists(dlqPath) {
 		return ErrResourceNotFound
 	}
 
+	return withQueueLock(dlqPath, func() error {
+		return filepath.WalkDir(dlqPath, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || d.Name() == metadataFile || filepath.Ext(d.Name()) != ".json" {
+				return nil
+			}
+			id := strings.TrimSuffix(d.Name(), ".json")
+			_ = withQueueIndex(dlqPath, func(idx *visibilityIndex) error {
+				return idx.delete(id)
+			})
+			return os.Remove(path)
+		})
+	})
+}
+
+// candidateMsgIds returns the message IDs worth inspecting for a GetMsg
+// call. When the bbolt-backed visibility index is available it range-scans
+// only the ids recorded visible at or before now, instead of walking every
+// message file in the queue directory; otherwise it falls back to a full
+// directory walk. A message whose Deadline expires while it is still leased
+// by another consumer is only caught once its ReenterTime passes and the
+// index reports it visible again, which is the tradeoff for not scanning
+// invisible messages on every call.
+func (c *LocalClient) candidateMsgIds(queuePath string, now time.Time) ([]string, error) {
+	var ids []string
+	err := withQueueIndex(queuePath, func(idx *visibilityIndex) error {
+		var err error
+		ids, err = idx.visibleBefore(now, 0)
+		return err
+	})
+	if err != nil {
+		return walkMsgIds(queuePath)
+	}
+	return ids, nil
+}
+
+func walkMsgIds(queuePath string) ([]string, error) {
+	var ids []string
+	err := filepath.WalkDir(queuePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() == metadataFile || filepath.Ext(d.Name()) != ".json" {
+			return nil
+		}
+		ids = append(ids, strings.TrimSuffix(d.Name(), ".json"))
+		return nil
+	})
+	return ids, err
+}
+
+func readMsgLocal(msgPath string) (*models.MsgLocal, error) {
 	buf, err := os.ReadFile(msgPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	var msg models.MsgLocal
-	err = json.Unmarshal(buf, &msg)
+	if err = json.Unmarshal(buf, &msg); err != nil {
+		return nil, fmt.Errorf("json unmarshal failed: %s", err)
+	}
+	return &msg, nil
+}
+
+func (c *LocalClient) readQueueMetadata(queueId string) (*models.Queue, error) {
+	metaPath := filepath.Join(storageDir, queueDir, queueId, metadataFile)
+	buf, err := os.ReadFile(metaPath)
 	if err != nil {
-		return fmt.Errorf("json unmarshal failed: %s", err)
+		return nil, fmt.Errorf("read file %s failed: %v", metaPath, err)
 	}
-	if msg.ReenterTime.Equal(time.Time{}) {
-		return ErrResourceNotFound
+
+	var queue models.Queue
+	if err = json.Unmarshal(buf, &queue); err != nil {
+		return nil, fmt.Errorf("json unmarshal failed: %s", err)
 	}
+	return &queue, nil
+}
 
-	if msg.ReenterTime.Before(time.Now()) {
-		return errors.New("msg is timeout, you must ack within the timeout period")
+// moveToDeadLetter relocates an exhausted message into its queue's DLQ,
+// preserving the original ID, queue ID, payload and retry count, and
+// recording why delivery failed.
+func (c *LocalClient) moveToDeadLetter(msg *models.MsgLocal, dlqId, reason string) error {
+	dlqPath := filepath.Join(storageDir, queueDir, dlqId)
+	if !isDirExists(dlqPath) {
+		return ErrResourceNotFound
 	}
-	err = os.Remove(msgPath)
+
+	dead := *msg
+	dead.FailedAt = time.Now().Unix()
+	dead.Desc = reason
+
+	marshal, err := json.Marshal(dead)
 	if err != nil {
-		return fmt.Errorf("delete file %s failed: %v", msgPath, err)
+		return fmt.Errorf("json marshal failed: %s", err)
 	}
+	msgPath := filepath.Join(dlqPath, fmt.Sprintf("%s.json", dead.ID))
+	if err = os.WriteFile(msgPath, marshal, os.ModePerm); err != nil {
+		return err
+	}
+	_ = withQueueIndex(dlqPath, func(idx *visibilityIndex) error {
+		return idx.put(dead.ID, time.Time{})
+	})
 	return nil
 }
 
@@ -314,3 +567,14 @@ func (c *LocalClient) updateMetadata(queue *models.Queue) error {
 	}
 	return os.WriteFile(path, marshal, os.ModePerm)
 }
+
+// writeFileAtomic writes data to a temp file in the same directory and
+// renames it into place, so concurrent readers never observe a partially
+// written message file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
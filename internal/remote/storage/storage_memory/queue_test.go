@@ -0,0 +1,206 @@
+package storage_memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/scrapeless-ai/sdk-go/internal/remote/storage/models"
+)
+
+// newTestQueue points storageDir at a fresh temp directory and creates a
+// queue in it, returning the queue id for use by the calling test.
+func newTestQueue(t *testing.T, dlqId string) string {
+	t.Helper()
+	storageDir = t.TempDir()
+
+	c := &LocalClient{}
+	resp, err := c.CreateQueue(context.Background(), &models.CreateQueueRequest{
+		Name:              "q-" + t.Name(),
+		DeadLetterQueueId: dlqId,
+	})
+	if err != nil {
+		t.Fatalf("CreateQueue failed: %v", err)
+	}
+	return resp.Id
+}
+
+func createTestMsg(t *testing.T, c *LocalClient, queueId string, retry int64) string {
+	t.Helper()
+	resp, err := c.CreateMsg(context.Background(), &models.CreateMsgRequest{
+		QueueId:  queueId,
+		Name:     "msg-" + t.Name(),
+		PayLoad:  "payload",
+		Deadline: time.Now().Unix() + 3600,
+		Retry:    retry,
+		Timeout:  30,
+	})
+	if err != nil {
+		t.Fatalf("CreateMsg failed: %v", err)
+	}
+	return resp.MsgId
+}
+
+// TestGetMsgReturnsFreshlyCreatedMessage is a regression test for the
+// visibilityKey zero-time overflow: a message that has never been leased is
+// indexed with time.Time{}, and GetMsg must still find it through the
+// bbolt-backed candidateMsgIds path on the very first call.
+func TestGetMsgReturnsFreshlyCreatedMessage(t *testing.T) {
+	queueId := newTestQueue(t, "")
+	c := &LocalClient{}
+	msgId := createTestMsg(t, c, queueId, 3)
+
+	resp, err := c.GetMsg(context.Background(), &models.GetMsgRequest{
+		QueueId: queueId,
+		Limit:   10,
+	})
+	if err != nil {
+		t.Fatalf("GetMsg failed: %v", err)
+	}
+	if len(*resp) != 1 || (*resp)[0].ID != msgId {
+		t.Fatalf("expected freshly created message %q to be visible, got %+v", msgId, *resp)
+	}
+}
+
+// TestExtendMsgKeepsLeaseAlive checks that ExtendMsg pushes ReenterTime far
+// enough forward that the message stays invisible to a second GetMsg call.
+func TestExtendMsgKeepsLeaseAlive(t *testing.T) {
+	queueId := newTestQueue(t, "")
+	c := &LocalClient{}
+	msgId := createTestMsg(t, c, queueId, 3)
+
+	if _, err := c.GetMsg(context.Background(), &models.GetMsgRequest{QueueId: queueId, Limit: 10}); err != nil {
+		t.Fatalf("initial GetMsg failed: %v", err)
+	}
+
+	if err := c.ExtendMsg(context.Background(), &models.ExtendMsgRequest{
+		QueueId:       queueId,
+		MsgId:         msgId,
+		ExtendSeconds: 60,
+	}); err != nil {
+		t.Fatalf("ExtendMsg failed: %v", err)
+	}
+
+	resp, err := c.GetMsg(context.Background(), &models.GetMsgRequest{QueueId: queueId, Limit: 10})
+	if err != nil {
+		t.Fatalf("GetMsg after extend failed: %v", err)
+	}
+	if len(*resp) != 0 {
+		t.Fatalf("expected extended message to stay invisible, got %+v", *resp)
+	}
+}
+
+// TestDeadLetterRoutingPreservesRetryCount checks that a message which
+// exhausts its retries is moved to its queue's DLQ, and that GetMsg on the
+// DLQ returns it without incrementing Retried further.
+func TestDeadLetterRoutingPreservesRetryCount(t *testing.T) {
+	storageDir = t.TempDir()
+	c := &LocalClient{}
+
+	dlqResp, err := c.CreateQueue(context.Background(), &models.CreateQueueRequest{Name: "dlq-" + t.Name()})
+	if err != nil {
+		t.Fatalf("CreateQueue(dlq) failed: %v", err)
+	}
+	dlqId := dlqResp.Id
+
+	srcResp, err := c.CreateQueue(context.Background(), &models.CreateQueueRequest{
+		Name:              "src-" + t.Name(),
+		DeadLetterQueueId: dlqId,
+	})
+	if err != nil {
+		t.Fatalf("CreateQueue(src) failed: %v", err)
+	}
+	queueId := srcResp.Id
+
+	// Retry: 0 means msg.Retried (0) >= msg.Retry (0) is already true on the
+	// very first scan, so the first GetMsg call moves it straight to the DLQ.
+	msgId := createTestMsg(t, c, queueId, 0)
+
+	if _, err = c.GetMsg(context.Background(), &models.GetMsgRequest{QueueId: queueId, Limit: 10}); err != nil {
+		t.Fatalf("GetMsg on source queue failed: %v", err)
+	}
+
+	resp, err := c.GetMsg(context.Background(), &models.GetMsgRequest{QueueId: dlqId, Limit: 10})
+	if err != nil {
+		t.Fatalf("GetMsg on dlq failed: %v", err)
+	}
+	if len(*resp) != 1 || (*resp)[0].ID != msgId {
+		t.Fatalf("expected dead-lettered message %q on dlq, got %+v", msgId, *resp)
+	}
+	if (*resp)[0].Retried != 0 {
+		t.Fatalf("expected Retried to stay 0 for a dlq message, got %d", (*resp)[0].Retried)
+	}
+
+	// Re-fetching from the dlq must not bump Retried either.
+	resp, err = c.GetMsg(context.Background(), &models.GetMsgRequest{QueueId: dlqId, Limit: 10})
+	if err != nil {
+		t.Fatalf("second GetMsg on dlq failed: %v", err)
+	}
+	if len(*resp) != 1 || (*resp)[0].Retried != 0 {
+		t.Fatalf("expected dlq message to still be visible with Retried 0, got %+v", *resp)
+	}
+}
+
+// TestGetMsgLongPollWakesOnCreate checks that a long-polling GetMsg call
+// returns as soon as a message becomes visible instead of waiting out the
+// full WaitSeconds deadline.
+func TestGetMsgLongPollWakesOnCreate(t *testing.T) {
+	queueId := newTestQueue(t, "")
+	c := &LocalClient{}
+
+	type result struct {
+		resp *models.GetMsgResponse
+		err  error
+	}
+	done := make(chan result, 1)
+	start := time.Now()
+	go func() {
+		resp, err := c.GetMsg(context.Background(), &models.GetMsgRequest{
+			QueueId:     queueId,
+			Limit:       10,
+			WaitSeconds: 5,
+		})
+		done <- result{resp, err}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	msgId := createTestMsg(t, c, queueId, 3)
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("GetMsg failed: %v", r.err)
+		}
+		if len(*r.resp) != 1 || (*r.resp)[0].ID != msgId {
+			t.Fatalf("expected long poll to return the new message, got %+v", *r.resp)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Fatalf("GetMsg took %v, expected to wake well before the 5s deadline", elapsed)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("GetMsg did not wake up after CreateMsg")
+	}
+}
+
+// TestAckMsgReleasesLease checks that AckMsg removes the message and its
+// in-process lease, and that acking it again fails with ErrResourceNotFound.
+func TestAckMsgReleasesLease(t *testing.T) {
+	queueId := newTestQueue(t, "")
+	c := &LocalClient{}
+	msgId := createTestMsg(t, c, queueId, 3)
+
+	if _, err := c.GetMsg(context.Background(), &models.GetMsgRequest{QueueId: queueId, Limit: 10}); err != nil {
+		t.Fatalf("GetMsg failed: %v", err)
+	}
+
+	if err := c.AckMsg(context.Background(), &models.AckMsgRequest{QueueId: queueId, MsgId: msgId}); err != nil {
+		t.Fatalf("AckMsg failed: %v", err)
+	}
+	if _, leased := c.sharedState.leases[msgId]; leased {
+		t.Fatalf("expected AckMsg to release the in-process lease on %q", msgId)
+	}
+
+	if err := c.AckMsg(context.Background(), &models.AckMsgRequest{QueueId: queueId, MsgId: msgId}); err != ErrResourceNotFound {
+		t.Fatalf("expected ErrResourceNotFound acking an already-acked message, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package storage_memory
+
+import (
+	"sync"
+	"time"
+)
+
+// inFlight records that this process has handed out a message and until
+// when its lease is considered valid.
+type inFlight struct {
+	ReenterTime time.Time
+}
+
+// sharedMsgState tracks in-flight leases held by this process, so that a
+// message this LocalClient has already handed out and whose lease has not
+// expired is not handed out again. It is checked in addition to the
+// per-queue fileLock, which opens a new handle on every call and therefore
+// serializes goroutines of this process as well as separate processes. The
+// zero value is ready to use.
+type sharedMsgState struct {
+	mu     sync.Mutex
+	leases map[string]*inFlight
+}
+
+// lease records msgID as leased by this process until reenterTime. It
+// reports false if msgID is already leased and that lease has not expired
+// yet, in which case the caller must not redeliver it.
+func (s *sharedMsgState) lease(msgID string, reenterTime time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.leases == nil {
+		s.leases = make(map[string]*inFlight)
+	}
+	if existing, ok := s.leases[msgID]; ok && existing.ReenterTime.After(time.Now()) {
+		return false
+	}
+	s.leases[msgID] = &inFlight{ReenterTime: reenterTime}
+	return true
+}
+
+// release drops msgID's lease, e.g. once it has been acked or dead-lettered.
+func (s *sharedMsgState) release(msgID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.leases, msgID)
+}